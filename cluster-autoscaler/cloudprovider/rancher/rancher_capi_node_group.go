@@ -0,0 +1,225 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rancher
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	schedulerframework "k8s.io/kubernetes/pkg/scheduler/framework"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// rancherCapiNodeGroup implements cloudprovider.NodeGroup backed by a
+// Cluster API MachineDeployment, used for clusters provisioned through
+// Rancher's RKE2/K3s provisioning v2 rather than legacy nodePools.
+type rancherCapiNodeGroup struct {
+	manager *RancherManager
+	name    string
+}
+
+// MaxSize returns maximum size of the node group, read from
+// capiMaxSizeAnnotation on the MachineDeployment, defaulting to unbounded
+// when absent so the group isn't pinned at its current replica count.
+func (ng *rancherCapiNodeGroup) MaxSize() int {
+	md, err := ng.manager.getMachineDeployment(ng.name)
+	if err != nil {
+		return 0
+	}
+	return capiSizeAnnotation(md, capiMaxSizeAnnotation, unboundedMaxSize)
+}
+
+// MinSize returns minimum size of the node group, read from
+// capiMinSizeAnnotation on the MachineDeployment, defaulting to 0.
+func (ng *rancherCapiNodeGroup) MinSize() int {
+	md, err := ng.manager.getMachineDeployment(ng.name)
+	if err != nil {
+		return 0
+	}
+	return capiSizeAnnotation(md, capiMinSizeAnnotation, 0)
+}
+
+// capiSizeAnnotation parses the integer annotation key off md, returning
+// def if the annotation is absent or unparsable.
+func capiSizeAnnotation(md *clusterv1.MachineDeployment, key string, def int) int {
+	raw, found := md.Annotations[key]
+	if !found {
+		return def
+	}
+	parsed, err := strconv.Atoi(raw)
+	if err != nil {
+		return def
+	}
+	return parsed
+}
+
+// TargetSize returns the current target size of the node group.
+func (ng *rancherCapiNodeGroup) TargetSize() (int, error) {
+	md, err := ng.manager.getMachineDeployment(ng.name)
+	if err != nil {
+		return 0, err
+	}
+	if md.Spec.Replicas == nil {
+		return 0, nil
+	}
+	return int(*md.Spec.Replicas), nil
+}
+
+// IncreaseSize increases the size of the node group by patching the
+// MachineDeployment's spec.replicas.
+func (ng *rancherCapiNodeGroup) IncreaseSize(delta int) error {
+	if delta <= 0 {
+		return fmt.Errorf("size increase must be positive, got: %d", delta)
+	}
+	current, err := ng.TargetSize()
+	if err != nil {
+		return err
+	}
+	return ng.setReplicas(current + delta)
+}
+
+// DeleteNodes deletes nodes from this node group by annotating the
+// Machine backing each one with capiDeleteMachineAnnotation, then lowering
+// spec.replicas; CAPI's machine-deployment controller prefers annotated
+// Machines when picking which one to remove for the new replica count.
+func (ng *rancherCapiNodeGroup) DeleteNodes(nodes []*apiv1.Node) error {
+	current, err := ng.TargetSize()
+	if err != nil {
+		return err
+	}
+	if current-len(nodes) < ng.MinSize() {
+		return fmt.Errorf("MachineDeployment %s size would drop below minimum", ng.name)
+	}
+	for _, node := range nodes {
+		if err := ng.markMachineForDeletion(node.Name); err != nil {
+			return err
+		}
+	}
+	return ng.setReplicas(current - len(nodes))
+}
+
+// markMachineForDeletion annotates the Machine backing the Kubernetes node
+// named nodeName with capiDeleteMachineAnnotation.
+func (ng *rancherCapiNodeGroup) markMachineForDeletion(nodeName string) error {
+	rn, err := ng.manager.GetCachedNodeForKubernetesNode(nodeName)
+	if err != nil {
+		return err
+	}
+	if rn.MachineName == "" {
+		return fmt.Errorf("no Machine annotation found for node %s", nodeName)
+	}
+
+	machine := &clusterv1.Machine{}
+	key := client.ObjectKey{Namespace: ng.manager.capiNamespace, Name: rn.MachineName}
+	if err := ng.manager.capiClient.Get(context.Background(), key, machine); err != nil {
+		return fmt.Errorf("failed to get Machine %s: %v", rn.MachineName, err)
+	}
+
+	patch := machine.DeepCopy()
+	if patch.Annotations == nil {
+		patch.Annotations = map[string]string{}
+	}
+	patch.Annotations[capiDeleteMachineAnnotation] = "true"
+	return ng.manager.capiClient.Patch(context.Background(), patch, client.MergeFrom(machine))
+}
+
+// DecreaseTargetSize decreases the target size of the node group.
+func (ng *rancherCapiNodeGroup) DecreaseTargetSize(delta int) error {
+	if delta >= 0 {
+		return fmt.Errorf("size decrease must be negative, got: %d", delta)
+	}
+	current, err := ng.TargetSize()
+	if err != nil {
+		return err
+	}
+	return ng.setReplicas(current + delta)
+}
+
+// setReplicas patches the MachineDeployment's spec.replicas to size.
+func (ng *rancherCapiNodeGroup) setReplicas(size int) error {
+	md, err := ng.manager.getMachineDeployment(ng.name)
+	if err != nil {
+		return err
+	}
+	replicas := int32(size)
+	patch := md.DeepCopy()
+	patch.Spec.Replicas = &replicas
+	return ng.manager.capiClient.Patch(context.Background(), patch, client.MergeFrom(md))
+}
+
+// Id returns the MachineDeployment name backing this node group.
+func (ng *rancherCapiNodeGroup) Id() string {
+	return ng.name
+}
+
+// Debug returns a human-readable description of the node group.
+func (ng *rancherCapiNodeGroup) Debug() string {
+	return fmt.Sprintf("rancher MachineDeployment %s", ng.name)
+}
+
+// Nodes returns a list of instances belonging to this node group.
+func (ng *rancherCapiNodeGroup) Nodes() ([]cloudprovider.Instance, error) {
+	if _, err := ng.manager.getMachineDeployment(ng.name); err != nil {
+		return nil, err
+	}
+
+	var instances []cloudprovider.Instance
+	for _, n := range ng.manager.nodesInMachineDeployment(ng.name) {
+		instances = append(instances, cloudprovider.Instance{Id: n.ID})
+	}
+	return instances, nil
+}
+
+// TemplateNodeInfo always returns cloudprovider.ErrNotImplemented: no
+// template node is built for MachineDeployment-backed node groups.
+func (ng *rancherCapiNodeGroup) TemplateNodeInfo() (*schedulerframework.NodeInfo, error) {
+	return nil, cloudprovider.ErrNotImplemented
+}
+
+// Exist checks if the MachineDeployment already exists.
+func (ng *rancherCapiNodeGroup) Exist() bool {
+	_, err := ng.manager.getMachineDeployment(ng.name)
+	return err == nil
+}
+
+// Create is unsupported: MachineDeployments backing Rancher node pools are
+// always provisioned through Rancher's cluster spec, never autoprovisioned
+// by the autoscaler directly.
+func (ng *rancherCapiNodeGroup) Create() (cloudprovider.NodeGroup, error) {
+	return nil, fmt.Errorf("MachineDeployment %s already exists and cannot be (re)created", ng.name)
+}
+
+// Delete is unsupported, see Create.
+func (ng *rancherCapiNodeGroup) Delete() error {
+	return cloudprovider.ErrNotImplemented
+}
+
+// Autoprovisioned returns false: MachineDeployments are always pre-existing.
+func (ng *rancherCapiNodeGroup) Autoprovisioned() bool {
+	return false
+}
+
+// GetOptions returns NodeGroupAutoscalingOptions for this node group, or
+// nil if the default ones should be used.
+func (ng *rancherCapiNodeGroup) GetOptions(defaults config.NodeGroupAutoscalingOptions) (*config.NodeGroupAutoscalingOptions, error) {
+	return nil, cloudprovider.ErrNotImplemented
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package rancher
 
 import (
+	"flag"
 	"fmt"
 
 	"k8s.io/api/core/v1"
@@ -27,9 +28,19 @@ import (
 	klog "k8s.io/klog/v2"
 )
 
+// rancherPricingConfigFile points at a YAML file of pricing table
+// overrides; see loadPricingTable. Empty uses defaultPricingTable.
+var rancherPricingConfigFile = flag.String("rancher-pricing-config", "",
+	"Path to a YAML file overriding the Rancher provider's built-in driver/machine-size pricing table")
+
 const (
-	// GPULabel is the label added to nodes with GPU resource.
+	// GPULabel is the default label added to nodes with GPU resource; use
+	// the `gpu-label` cloud-config setting to override it per-manager.
 	GPULabel = "nodes.pkds.it/gpu-node"
+
+	// gpuResourceName is the extended resource TemplateNodeInfo reports
+	// GPU capacity under.
+	gpuResourceName = v1.ResourceName("nvidia.com/gpu")
 )
 
 // CloudProvider contains configuration info and functions for interacting with
@@ -37,11 +48,12 @@ const (
 type rancherCloudProvider struct {
 	manager         *RancherManager
 	resourceLimiter *cloudprovider.ResourceLimiter
+	pricingModel    *rancherPricingModel
 }
 
 // BuildRancher builds Rancher cloud provider, manager etc.
 func BuildRancher(opts config.AutoscalingOptions, do cloudprovider.NodeGroupDiscoveryOptions, rl *cloudprovider.ResourceLimiter) cloudprovider.CloudProvider {
-	manager, err := BuildRancherManager()
+	manager, err := BuildRancherManager(opts.CloudConfig, do)
 	if err != nil {
 		klog.Fatalf("Failed to create Rancher Manager: %v", err)
 	}
@@ -54,9 +66,19 @@ func BuildRancher(opts config.AutoscalingOptions, do cloudprovider.NodeGroupDisc
 
 // BuildRancherCloudProvider creates new rancherCloudProvider
 func BuildRancherCloudProvider(manager *RancherManager, resourceLimiter *cloudprovider.ResourceLimiter) (cloudprovider.CloudProvider, error) {
+	table := defaultPricingTable
+	if *rancherPricingConfigFile != "" {
+		loaded, err := loadPricingTable(*rancherPricingConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		table = loaded
+	}
+
 	rancher := &rancherCloudProvider{
 		manager:         manager,
 		resourceLimiter: resourceLimiter,
+		pricingModel:    &rancherPricingModel{manager: manager, table: table},
 	}
 
 	return rancher, nil
@@ -82,31 +104,43 @@ func (cp *rancherCloudProvider) NodeGroups() []cloudprovider.NodeGroup {
 // should not be processed by cluster autoscaler, or non-nil error if such
 // occurred. Must be implemented.
 func (cp *rancherCloudProvider) NodeGroupForNode(node *v1.Node) (cloudprovider.NodeGroup, error) {
+	// A Cluster API-backed node carries its MachineDeployment ownership
+	// directly on the workload Node's own annotations; read it from there
+	// rather than the Rancher management-plane node object, which isn't
+	// guaranteed to mirror it.
+	if name := node.Annotations[capiDeploymentAnnotation]; name != "" {
+		return &rancherCapiNodeGroup{manager: cp.manager, name: name}, nil
+	}
+
 	rancherNode, err := cp.manager.GetCachedNodeForKubernetesNode(node.Name)
 	if err != nil {
 		return nil, err
 	}
 
-	if rancherNode.NodePoolID == "" {
-		return nil, fmt.Errorf("missing node pool name for node %s (%s)", rancherNode.NodeName, rancherNode.ID)
+	// A node carries at most one of the two owner references, depending on
+	// whether it was provisioned as a legacy nodePool member or via
+	// Cluster API (RKE2/K3s provisioning v2); a node with neither is an
+	// error.
+	switch {
+	case rancherNode.NodePoolID != "":
+		return &rancherNodeGroup{manager: cp.manager, id: rancherNode.NodePoolID}, nil
+	case rancherNode.MachineDeploymentName != "":
+		return &rancherCapiNodeGroup{manager: cp.manager, name: rancherNode.MachineDeploymentName}, nil
+	default:
+		return nil, fmt.Errorf("missing node pool and MachineDeployment owner for node %s (%s)", rancherNode.NodeName, rancherNode.ID)
 	}
-
-	return &rancherNodeGroup{
-		manager: cp.manager,
-		id:      rancherNode.NodePoolID,
-	}, nil
 }
 
 // Pricing returns pricing model for this cloud provider or error if not available.
 // Implementation optional.
 func (cp *rancherCloudProvider) Pricing() (cloudprovider.PricingModel, errors.AutoscalerError) {
-	return nil, cloudprovider.ErrNotImplemented
+	return cp.pricingModel, nil
 }
 
 // GetAvailableMachineTypes get all machine types that can be requested from the cloud provider.
 // Implementation optional.
 func (cp *rancherCloudProvider) GetAvailableMachineTypes() ([]string, error) {
-	return nil, cloudprovider.ErrNotImplemented
+	return cp.manager.GetAvailableMachineTypes()
 }
 
 // NewNodeGroup builds a theoretical node group based on the node definition provided. The node group is not automatically
@@ -114,7 +148,11 @@ func (cp *rancherCloudProvider) GetAvailableMachineTypes() ([]string, error) {
 // Implementation optional.
 func (cp *rancherCloudProvider) NewNodeGroup(machineType string, labels map[string]string, systemLabels map[string]string,
 	taints []v1.Taint, extraResources map[string]resource.Quantity) (cloudprovider.NodeGroup, error) {
-	return nil, cloudprovider.ErrNotImplemented
+	pool, err := cp.manager.createNodePool(machineType, labels, taints)
+	if err != nil {
+		return nil, err
+	}
+	return &rancherNodeGroup{manager: cp.manager, id: pool.ID}, nil
 }
 
 // GetResourceLimiter returns struct containing limits (max, min) for resources (cores, memory etc.).
@@ -124,12 +162,12 @@ func (cp *rancherCloudProvider) GetResourceLimiter() (*cloudprovider.ResourceLim
 
 // GPULabel returns the label added to nodes with GPU resource.
 func (cp *rancherCloudProvider) GPULabel() string {
-	return GPULabel
+	return cp.manager.GPULabel()
 }
 
 // GetAvailableGPUTypes return all available GPU types cloud provider supports.
 func (cp *rancherCloudProvider) GetAvailableGPUTypes() map[string]struct{} {
-	return nil
+	return cp.manager.GetAvailableGPUTypes()
 }
 
 // Cleanup cleans up open resources before the cloud provider is destroyed, i.e. go routines etc.
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rancher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// autoDiscoveryConfig is the parsed form of a
+// `--node-group-auto-discovery=rancher:label=<k>=<v>,clusterId=<id>` spec.
+type autoDiscoveryConfig struct {
+	Labels    map[string]string
+	ClusterID string
+}
+
+// staticNodeGroupSpec is the parsed form of a `--nodes=min:max:poolID`
+// spec, which overrides whatever min/max the pool has configured in
+// Rancher.
+type staticNodeGroupSpec struct {
+	MinSize int
+	MaxSize int
+	PoolID  string
+}
+
+// parseAutoDiscoverySpecs parses the `rancher:` prefixed entries of
+// `--node-group-auto-discovery`. Specs for other providers are ignored, as
+// the autoscaler may be invoked with discovery specs for several providers
+// at once.
+func parseAutoDiscoverySpecs(specs []string) (*autoDiscoveryConfig, error) {
+	cfg := &autoDiscoveryConfig{Labels: make(map[string]string)}
+	for _, spec := range specs {
+		provider, rest, found := strings.Cut(spec, ":")
+		if !found || provider != "rancher" {
+			continue
+		}
+		for _, field := range strings.Split(rest, ",") {
+			if field == "" {
+				continue
+			}
+			key, value, found := strings.Cut(field, "=")
+			if !found {
+				return nil, fmt.Errorf("invalid rancher auto-discovery field %q, expected key=value", field)
+			}
+			switch key {
+			case "clusterId":
+				cfg.ClusterID = value
+			case "label":
+				k, v, found := strings.Cut(value, "=")
+				if !found {
+					return nil, fmt.Errorf("invalid rancher auto-discovery label %q, expected <k>=<v>", value)
+				}
+				cfg.Labels[k] = v
+			default:
+				return nil, fmt.Errorf("unknown rancher auto-discovery field %q", key)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// parseStaticNodeGroupSpecs parses `--nodes=min:max:poolID` entries, same
+// syntax as the AWS/GCE/Azure providers use for their static node groups.
+func parseStaticNodeGroupSpecs(specs []string) ([]staticNodeGroupSpec, error) {
+	var parsed []staticNodeGroupSpec
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid rancher node group spec %q, expected min:max:poolID", spec)
+		}
+		min, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid min size in node group spec %q: %v", spec, err)
+		}
+		max, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid max size in node group spec %q: %v", spec, err)
+		}
+		if min < 0 || max < min {
+			return nil, fmt.Errorf("invalid min/max in node group spec %q", spec)
+		}
+		parsed = append(parsed, staticNodeGroupSpec{MinSize: min, MaxSize: max, PoolID: parts[2]})
+	}
+	return parsed, nil
+}
+
+// matchesLabels reports whether pool carries every label in selector.
+func matchesLabels(pool *nodePool, selector map[string]string) bool {
+	for k, v := range selector {
+		if pool.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
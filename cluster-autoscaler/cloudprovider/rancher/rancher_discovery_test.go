@@ -0,0 +1,170 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rancher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAutoDiscoverySpecs(t *testing.T) {
+	testCases := []struct {
+		name    string
+		specs   []string
+		want    *autoDiscoveryConfig
+		wantErr bool
+	}{
+		{
+			name:  "no specs",
+			specs: nil,
+			want:  &autoDiscoveryConfig{Labels: map[string]string{}},
+		},
+		{
+			name:  "specs for another provider are ignored",
+			specs: []string{"asg:tag=foo"},
+			want:  &autoDiscoveryConfig{Labels: map[string]string{}},
+		},
+		{
+			name:  "clusterId and label",
+			specs: []string{"rancher:clusterId=c-abc123,label=pool=gpu"},
+			want: &autoDiscoveryConfig{
+				ClusterID: "c-abc123",
+				Labels:    map[string]string{"pool": "gpu"},
+			},
+		},
+		{
+			name:    "missing value",
+			specs:   []string{"rancher:clusterId"},
+			wantErr: true,
+		},
+		{
+			name:    "malformed label",
+			specs:   []string{"rancher:label=pool"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			specs:   []string{"rancher:foo=bar"},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseAutoDiscoverySpecs(tc.specs)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseStaticNodeGroupSpecs(t *testing.T) {
+	testCases := []struct {
+		name    string
+		specs   []string
+		want    []staticNodeGroupSpec
+		wantErr bool
+	}{
+		{
+			name:  "no specs",
+			specs: nil,
+			want:  nil,
+		},
+		{
+			name:  "single spec",
+			specs: []string{"1:5:np-abc123"},
+			want:  []staticNodeGroupSpec{{MinSize: 1, MaxSize: 5, PoolID: "np-abc123"}},
+		},
+		{
+			name:    "too few parts",
+			specs:   []string{"1:5"},
+			wantErr: true,
+		},
+		{
+			name:    "non-numeric min",
+			specs:   []string{"a:5:np-abc123"},
+			wantErr: true,
+		},
+		{
+			name:    "non-numeric max",
+			specs:   []string{"1:b:np-abc123"},
+			wantErr: true,
+		},
+		{
+			name:    "negative min",
+			specs:   []string{"-1:5:np-abc123"},
+			wantErr: true,
+		},
+		{
+			name:    "max below min",
+			specs:   []string{"5:1:np-abc123"},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseStaticNodeGroupSpecs(tc.specs)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMatchesLabels(t *testing.T) {
+	pool := &nodePool{Labels: map[string]string{"pool": "gpu", "env": "prod"}}
+
+	testCases := []struct {
+		name     string
+		selector map[string]string
+		want     bool
+	}{
+		{name: "empty selector matches", selector: map[string]string{}, want: true},
+		{name: "matching subset", selector: map[string]string{"pool": "gpu"}, want: true},
+		{name: "matching full set", selector: map[string]string{"pool": "gpu", "env": "prod"}, want: true},
+		{name: "mismatched value", selector: map[string]string{"pool": "cpu"}, want: false},
+		{name: "missing label", selector: map[string]string{"team": "infra"}, want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := matchesLabels(pool, tc.selector); got != tc.want {
+				t.Errorf("matchesLabels() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
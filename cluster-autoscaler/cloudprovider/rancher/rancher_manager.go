@@ -0,0 +1,766 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rancher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/gcfg.v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/client-go/rest"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// defaultRefreshInterval is how often the local node/nodePool cache is
+	// rebuilt from the Rancher API when Refresh() is called.
+	defaultRefreshInterval = 1 * time.Minute
+
+	// defaultDriverName is the kontainer-engine node driver used to
+	// provision new node pools when none is configured explicitly.
+	defaultDriverName = "amazonec2"
+
+	// capiDeploymentAnnotation is set by Cluster API / Rancher provisioning
+	// v2 on every v1.Node that belongs to a MachineDeployment, naming it.
+	capiDeploymentAnnotation = "cluster.x-k8s.io/deployment-name"
+	// capiMachineAnnotation names the Machine object backing the node.
+	capiMachineAnnotation = "cluster.x-k8s.io/machine"
+
+	// capiMinSizeAnnotation/capiMaxSizeAnnotation bound a MachineDeployment
+	// node group's size, mirroring the annotations upstream
+	// cluster-autoscaler's clusterapi provider reads off the same objects.
+	capiMinSizeAnnotation = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-min-size"
+	capiMaxSizeAnnotation = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-max-size"
+
+	// capiDeleteMachineAnnotation marks a Machine for preferential removal
+	// by CAPI's machine-deployment controller the next time spec.replicas
+	// is lowered, letting DeleteNodes pick the exact Machine to remove.
+	capiDeleteMachineAnnotation = "cluster.x-k8s.io/delete-machine"
+
+	// unboundedMaxSize is the default MaxSize for a node group whose actual
+	// upper bound isn't known from an annotation or static spec, so that
+	// the group isn't pinned at whatever its current size happens to be.
+	unboundedMaxSize = math.MaxInt32
+
+	// theoreticalPoolTTL bounds how long a theoretical pool built by
+	// createNodePool survives without being realized by Create(). It's
+	// sized well above a single scheduling simulation pass so a candidate
+	// that wins its simulation has time to reach Create(), while candidates
+	// the simulation discards don't accumulate in the cache indefinitely.
+	theoreticalPoolTTL = 10 * time.Minute
+
+	// gpuTypeAnnotation, when set on a nodeTemplate, names the GPU model
+	// (e.g. "nvidia-tesla-t4") its nodes are provisioned with.
+	gpuTypeAnnotation = "autoscaler.pkds.it/gpu-type"
+	// gpuCountAnnotation, when set on a nodeTemplate, gives the number of
+	// GPUs its nodes are provisioned with. Defaults to 1 when gpuTypeAnnotation
+	// is set but this one is absent.
+	gpuCountAnnotation = "autoscaler.pkds.it/gpu-count"
+
+	// cpuAnnotation and memoryAnnotation, when set on a nodeTemplate,
+	// override defaultMachineFlavors' CPU core count and memory in MiB;
+	// podsAnnotation overrides defaultMaxPods.
+	cpuAnnotation    = "autoscaler.pkds.it/cpu"
+	memoryAnnotation = "autoscaler.pkds.it/memory-mb"
+	podsAnnotation   = "autoscaler.pkds.it/pods"
+
+	// defaultMaxPods is the max pods capacity assumed for a template node
+	// when podsAnnotation is absent or unparsable, matching the kubelet's
+	// own default.
+	defaultMaxPods = 110
+)
+
+// machineFlavor is the CPU/memory shape of a driver + instance type pair,
+// used to size template nodes for scale-from-zero simulation when no
+// nodeTemplate annotation gives it directly.
+type machineFlavor struct {
+	CPU       int64
+	MemoryMiB int64
+}
+
+// defaultMachineFlavors covers the node drivers PlayKids runs in practice;
+// operators with other drivers, or custom instance types, should set
+// cpuAnnotation/memoryAnnotation on the nodeTemplate instead.
+var defaultMachineFlavors = map[string]map[string]machineFlavor{
+	"amazonec2": {
+		"m5.large":   {CPU: 2, MemoryMiB: 8192},
+		"m5.xlarge":  {CPU: 4, MemoryMiB: 16384},
+		"m5.2xlarge": {CPU: 8, MemoryMiB: 32768},
+	},
+	"digitalocean": {
+		"s-2vcpu-4gb": {CPU: 2, MemoryMiB: 4096},
+		"s-4vcpu-8gb": {CPU: 4, MemoryMiB: 8192},
+	},
+}
+
+// rancherConfig is the `--cloud-config` file format for the Rancher provider.
+type rancherConfig struct {
+	Global struct {
+		APIURL    string `gcfg:"api-url"`
+		Token     string `gcfg:"token"`
+		ClusterID string `gcfg:"cluster-id"`
+		// Driver is the kontainer-engine/node-driver used when provisioning
+		// brand new node pools via NewNodeGroup (e.g. "amazonec2", "digitalocean").
+		Driver string `gcfg:"driver"`
+		// CAPINamespace is the namespace MachineDeployments for this
+		// cluster live in on the local/management cluster, e.g.
+		// "fleet-default". Leave empty to disable CAPI-backed node groups.
+		CAPINamespace string `gcfg:"capi-namespace"`
+		// GPULabel overrides the default node label used to recognize GPU
+		// nodes (see the package-level GPULabel constant).
+		GPULabel string `gcfg:"gpu-label"`
+	}
+}
+
+// nodeTemplate mirrors the subset of a Rancher nodeTemplate object the
+// autoscaler cares about.
+type nodeTemplate struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+	// DriverConfig holds the driver-specific settings (instance type,
+	// droplet size, etc.) that Rancher nests under the template.
+	DriverConfig map[string]interface{} `json:"driverConfig"`
+	Annotations  map[string]string      `json:"annotations"`
+}
+
+// nodePool mirrors the subset of a Rancher nodePool object the autoscaler
+// cares about.
+type nodePool struct {
+	ID             string            `json:"id"`
+	ClusterID      string            `json:"clusterId"`
+	NodeTemplateID string            `json:"nodeTemplateId"`
+	Quantity       int               `json:"quantity"`
+	Labels         map[string]string `json:"labels"`
+	Taints         []apiv1.Taint     `json:"taints"`
+	Annotations    map[string]string `json:"annotations"`
+	// theoretical marks node groups created by NewNodeGroup that have not
+	// yet been persisted to Rancher via Create(), so they must stay out of
+	// GetCachedNodeGroups() until then.
+	theoretical bool
+	// pendingTemplate holds the nodeTemplate built by createNodePool for a
+	// theoretical pool, kept purely in-memory until Create() persists it;
+	// nil once NodeTemplateID refers to a real, API-created template.
+	pendingTemplate *nodeTemplate
+	// theoreticalSince records when createNodePool created this pool, so
+	// Refresh can evict it once theoreticalPoolTTL has passed without a
+	// Create() call realizing it. Autoprovisioning simulates many candidate
+	// machine types per loop and discards nearly all of them; without this,
+	// discarded candidates would accumulate in the cache forever.
+	theoreticalSince time.Time
+	// minSize/maxSize are set by Refresh to 0/unboundedMaxSize, unless a
+	// `--nodes=min:max:poolID` static spec overrides them so operators can
+	// bound autoscaling without editing the Rancher UI.
+	minSize int
+	maxSize int
+}
+
+// rancherNode mirrors the subset of a Rancher node object the autoscaler
+// cares about. At most one of NodePoolID / MachineDeploymentName is set,
+// depending on whether the node was provisioned as a legacy nodePool
+// member or via Cluster API (RKE2/K3s provisioning v2).
+type rancherNode struct {
+	ID                    string
+	NodeName              string
+	NodePoolID            string
+	MachineDeploymentName string
+	// MachineName names the Machine object backing this node, set from
+	// capiMachineAnnotation, when it belongs to a MachineDeployment.
+	MachineName string
+}
+
+// RancherManager handles Rancher API communication and caches node pools,
+// MachineDeployments and nodes so that NodeGroups() and NodeGroupForNode()
+// don't hit the API on every call.
+type RancherManager struct {
+	client    *http.Client
+	apiURL    string
+	token     string
+	clusterID string
+	driver    string
+	// gpuLabel is the node label used to recognize GPU nodes, defaulting
+	// to the package-level GPULabel constant.
+	gpuLabel string
+
+	// capiClient talks to the local/management cluster that owns the
+	// MachineDeployments backing this Rancher cluster, when provisioned via
+	// Cluster API. Nil if capi-namespace isn't configured.
+	capiClient    client.Client
+	capiNamespace string
+
+	// discovery restricts GetCachedNodeGroups to pools matching a label
+	// selector and/or Rancher cluster ID, as set by
+	// `--node-group-auto-discovery=rancher:...`. Nil disables filtering.
+	discovery *autoDiscoveryConfig
+	// staticSpecs overrides a pool's min/max size, keyed by pool ID, as
+	// set by `--nodes=min:max:poolID`.
+	staticSpecs map[string]staticNodeGroupSpec
+
+	cacheMutex         sync.Mutex
+	nodePools          map[string]*nodePool
+	nodeTemplates      map[string]*nodeTemplate
+	machineDeployments map[string]*clusterv1.MachineDeployment
+	nodes              map[string]*rancherNode
+}
+
+// BuildRancherManager creates a RancherManager from the `--cloud-config`
+// file referenced by configFile, or from the RANCHER_* environment
+// variables if configFile is empty. do configures label/annotation and
+// static node group discovery, see parseAutoDiscoverySpecs and
+// parseStaticNodeGroupSpecs.
+func BuildRancherManager(configFile string, do cloudprovider.NodeGroupDiscoveryOptions) (*RancherManager, error) {
+	discovery, err := parseAutoDiscoverySpecs(do.NodeGroupAutoDiscoverySpecs)
+	if err != nil {
+		return nil, err
+	}
+	staticSpecList, err := parseStaticNodeGroupSpecs(do.NodeGroupSpecs)
+	if err != nil {
+		return nil, err
+	}
+	staticSpecs := make(map[string]staticNodeGroupSpec, len(staticSpecList))
+	for _, s := range staticSpecList {
+		staticSpecs[s.PoolID] = s
+	}
+
+	var cfg rancherConfig
+	if configFile != "" {
+		f, err := os.Open(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open cloud config %q: %v", configFile, err)
+		}
+		defer f.Close()
+		if err := gcfg.ReadInto(&cfg, f); err != nil {
+			return nil, fmt.Errorf("failed to parse cloud config %q: %v", configFile, err)
+		}
+	} else {
+		cfg.Global.APIURL = os.Getenv("RANCHER_API_URL")
+		cfg.Global.Token = os.Getenv("RANCHER_TOKEN")
+		cfg.Global.ClusterID = os.Getenv("RANCHER_CLUSTER_ID")
+		cfg.Global.Driver = os.Getenv("RANCHER_DRIVER")
+	}
+
+	if cfg.Global.APIURL == "" || cfg.Global.Token == "" || cfg.Global.ClusterID == "" {
+		return nil, fmt.Errorf("rancher cloud config must set api-url, token and cluster-id")
+	}
+
+	driver := cfg.Global.Driver
+	if driver == "" {
+		driver = defaultDriverName
+	}
+
+	gpuLabel := cfg.Global.GPULabel
+	if gpuLabel == "" {
+		gpuLabel = GPULabel
+	}
+
+	m := &RancherManager{
+		client:             &http.Client{Timeout: 30 * time.Second},
+		apiURL:             cfg.Global.APIURL,
+		token:              cfg.Global.Token,
+		clusterID:          cfg.Global.ClusterID,
+		driver:             driver,
+		gpuLabel:           gpuLabel,
+		capiNamespace:      cfg.Global.CAPINamespace,
+		discovery:          discovery,
+		staticSpecs:        staticSpecs,
+		nodePools:          make(map[string]*nodePool),
+		nodeTemplates:      make(map[string]*nodeTemplate),
+		machineDeployments: make(map[string]*clusterv1.MachineDeployment),
+		nodes:              make(map[string]*rancherNode),
+	}
+
+	if m.capiNamespace != "" {
+		capiClient, err := newCAPIClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build Cluster API client: %v", err)
+		}
+		m.capiClient = capiClient
+	}
+
+	if err := m.Refresh(); err != nil {
+		return nil, fmt.Errorf("failed initial Rancher cache load: %v", err)
+	}
+
+	return m, nil
+}
+
+// newCAPIClient builds a controller-runtime client for the management
+// cluster that hosts this Rancher cluster's MachineDeployments, using
+// in-cluster config (the autoscaler itself runs as a pod on that cluster).
+func newCAPIClient() (client.Client, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	scheme := runtime.NewScheme()
+	if err := clusterv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	return client.New(cfg, client.Options{Scheme: scheme})
+}
+
+// do performs an authenticated request against the Rancher API and decodes
+// the JSON response body into out, if out is non-nil.
+func (m *RancherManager) do(method, path string, body interface{}, out interface{}) error {
+	var reqBody *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	} else {
+		reqBody = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, m.apiURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(m.token, "")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("rancher API %s %s returned %d: %s", method, path, resp.StatusCode, string(data))
+	}
+	if out != nil && len(data) > 0 {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// Refresh rebuilds the node pool and node caches from the Rancher API.
+func (m *RancherManager) Refresh() error {
+	var poolList struct {
+		Data []*nodePool `json:"data"`
+	}
+	if err := m.do(http.MethodGet, "/clusters/"+m.clusterID+"/nodepools", nil, &poolList); err != nil {
+		return fmt.Errorf("failed to list node pools: %v", err)
+	}
+
+	var templateList struct {
+		Data []*nodeTemplate `json:"data"`
+	}
+	if err := m.do(http.MethodGet, "/nodeTemplates", nil, &templateList); err != nil {
+		return fmt.Errorf("failed to list node templates: %v", err)
+	}
+
+	var nodeList struct {
+		Data []struct {
+			ID          string            `json:"id"`
+			NodeName    string            `json:"nodeName"`
+			NodePoolID  string            `json:"nodePoolId"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"data"`
+	}
+	if err := m.do(http.MethodGet, "/clusters/"+m.clusterID+"/nodes", nil, &nodeList); err != nil {
+		return fmt.Errorf("failed to list nodes: %v", err)
+	}
+
+	var deployments *clusterv1.MachineDeploymentList
+	if m.capiClient != nil {
+		deployments = &clusterv1.MachineDeploymentList{}
+		if err := m.capiClient.List(context.Background(), deployments, client.InNamespace(m.capiNamespace)); err != nil {
+			return fmt.Errorf("failed to list MachineDeployments: %v", err)
+		}
+	}
+
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	for _, existing := range m.nodePools {
+		if existing.theoretical && time.Since(existing.theoreticalSince) < theoreticalPoolTTL {
+			// Keep not-yet-created pools around across refreshes; the
+			// Rancher API doesn't know about them yet. Pools older than
+			// theoreticalPoolTTL are simulation candidates that lost and
+			// were never realized by Create(), so they're dropped here
+			// instead of being kept forever.
+			poolList.Data = append(poolList.Data, existing)
+		}
+	}
+
+	pools := make(map[string]*nodePool, len(poolList.Data))
+	for _, p := range poolList.Data {
+		// Without an operator-supplied bound, default to unbounded rather
+		// than the pool's current quantity: pinning maxSize to the live
+		// replica count would make MaxSize() == TargetSize() and the
+		// autoscaler would never be allowed to scale the pool up.
+		p.minSize, p.maxSize = 0, unboundedMaxSize
+		if override, found := m.staticSpecs[p.ID]; found {
+			p.minSize, p.maxSize = override.MinSize, override.MaxSize
+		}
+		pools[p.ID] = p
+	}
+
+	nodes := make(map[string]*rancherNode, len(nodeList.Data))
+	for _, n := range nodeList.Data {
+		rn := &rancherNode{ID: n.ID, NodeName: n.NodeName, NodePoolID: n.NodePoolID}
+		// A node provisioned via Cluster API carries a nodePoolId on
+		// legacy clusters but not on CAPI-managed ones; fall back to the
+		// deployment annotation to resolve ownership in that case. This is
+		// used to enumerate a MachineDeployment's member nodes
+		// (rancherCapiNodeGroup.Nodes()), to find the Machine to mark in
+		// DeleteNodes, and by NodeGroupForNode only as a fallback when the
+		// workload Node itself carries no deployment annotation.
+		if rn.NodePoolID == "" {
+			rn.MachineDeploymentName = n.Annotations[capiDeploymentAnnotation]
+			rn.MachineName = n.Annotations[capiMachineAnnotation]
+		}
+		nodes[n.NodeName] = rn
+	}
+
+	machineDeployments := make(map[string]*clusterv1.MachineDeployment)
+	if deployments != nil {
+		for i := range deployments.Items {
+			md := &deployments.Items[i]
+			machineDeployments[md.Name] = md
+		}
+	}
+
+	templates := make(map[string]*nodeTemplate, len(templateList.Data))
+	for _, t := range templateList.Data {
+		templates[t.ID] = t
+	}
+
+	m.nodePools = pools
+	m.nodes = nodes
+	m.machineDeployments = machineDeployments
+	m.nodeTemplates = templates
+	return nil
+}
+
+// GetCachedNodeGroups returns a rancherNodeGroup for every non-theoretical
+// node pool in the cache that matches the configured auto-discovery
+// selector (if any), plus a rancherCapiNodeGroup for every cached
+// MachineDeployment.
+func (m *RancherManager) GetCachedNodeGroups() ([]cloudprovider.NodeGroup, error) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	var groups []cloudprovider.NodeGroup
+	for id, p := range m.nodePools {
+		if p.theoretical {
+			continue
+		}
+		if m.discovery != nil {
+			if m.discovery.ClusterID != "" && p.ClusterID != m.discovery.ClusterID {
+				continue
+			}
+			if len(m.discovery.Labels) > 0 && !matchesLabels(p, m.discovery.Labels) {
+				continue
+			}
+		}
+		groups = append(groups, &rancherNodeGroup{manager: m, id: id})
+	}
+	for name := range m.machineDeployments {
+		groups = append(groups, &rancherCapiNodeGroup{manager: m, name: name})
+	}
+	return groups, nil
+}
+
+// GetCachedNodeForKubernetesNode returns the Rancher node backing the given
+// Kubernetes node name.
+func (m *RancherManager) GetCachedNodeForKubernetesNode(name string) (*rancherNode, error) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	n, found := m.nodes[name]
+	if !found {
+		return nil, fmt.Errorf("no rancher node found for kubernetes node %s", name)
+	}
+	return n, nil
+}
+
+// getNodePool returns the cached nodePool for id, or an error if it isn't
+// known.
+func (m *RancherManager) getNodePool(id string) (*nodePool, error) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	p, found := m.nodePools[id]
+	if !found {
+		return nil, fmt.Errorf("unknown rancher node pool %s", id)
+	}
+	return p, nil
+}
+
+// getNodeTemplate returns the cached nodeTemplate for id, or an error if it
+// isn't known.
+func (m *RancherManager) getNodeTemplate(id string) (*nodeTemplate, error) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	t, found := m.nodeTemplates[id]
+	if !found {
+		return nil, fmt.Errorf("unknown rancher node template %s", id)
+	}
+	return t, nil
+}
+
+// getMachineDeployment returns the cached MachineDeployment for name, or an
+// error if it isn't known.
+func (m *RancherManager) getMachineDeployment(name string) (*clusterv1.MachineDeployment, error) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	md, found := m.machineDeployments[name]
+	if !found {
+		return nil, fmt.Errorf("unknown MachineDeployment %s", name)
+	}
+	return md, nil
+}
+
+// GetAvailableMachineTypes lists the driver flavors advertised by every
+// cached nodeTemplate matching the manager's configured driver, since
+// Rancher has no single endpoint enumerating "machine types" independent
+// of a driver.
+func (m *RancherManager) GetAvailableMachineTypes() ([]string, error) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	seen := make(map[string]bool)
+	var types []string
+	for _, t := range m.nodeTemplates {
+		if t.Driver != m.driver {
+			continue
+		}
+		machineSize, _ := t.DriverConfig["instanceType"].(string)
+		if machineSize == "" || seen[machineSize] {
+			continue
+		}
+		seen[machineSize] = true
+		types = append(types, machineSize)
+	}
+	return types, nil
+}
+
+// GPULabel returns the node label used to recognize GPU nodes.
+func (m *RancherManager) GPULabel() string {
+	return m.gpuLabel
+}
+
+// GetAvailableGPUTypes returns every GPU type advertised via
+// gpuTypeAnnotation across the cached nodeTemplates.
+func (m *RancherManager) GetAvailableGPUTypes() map[string]struct{} {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	types := make(map[string]struct{})
+	for _, t := range m.nodeTemplates {
+		if gpuType := t.Annotations[gpuTypeAnnotation]; gpuType != "" {
+			types[gpuType] = struct{}{}
+		}
+	}
+	return types
+}
+
+// resolveTemplate returns the nodeTemplate describing pool, whether it's
+// already a real, cached template or still only pool's in-memory
+// pendingTemplate.
+func (m *RancherManager) resolveTemplate(pool *nodePool) *nodeTemplate {
+	if pool.pendingTemplate != nil {
+		return pool.pendingTemplate
+	}
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	return m.nodeTemplates[pool.NodeTemplateID]
+}
+
+// gpuSpecForPool returns the GPU type and count advertised by pool's
+// nodeTemplate, or ("", 0) if it carries no GPU annotation.
+func (m *RancherManager) gpuSpecForPool(pool *nodePool) (string, int64) {
+	tmpl := m.resolveTemplate(pool)
+	if tmpl == nil {
+		return "", 0
+	}
+	gpuType := tmpl.Annotations[gpuTypeAnnotation]
+	if gpuType == "" {
+		return "", 0
+	}
+	count := int64(1)
+	if raw := tmpl.Annotations[gpuCountAnnotation]; raw != "" {
+		if parsed, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			count = parsed
+		}
+	}
+	return gpuType, count
+}
+
+// resourceSpecForPool returns the CPU (cores), memory (bytes) and max pods
+// capacity advertised by pool's nodeTemplate, used to populate a template
+// node for scale-from-zero simulation. Values come from the well-known
+// annotations first, then defaultMachineFlavors keyed by driver + instance
+// type; any capacity still unknown is returned as 0.
+func (m *RancherManager) resourceSpecForPool(pool *nodePool) (cpu int64, memoryBytes int64, pods int64) {
+	tmpl := m.resolveTemplate(pool)
+	if tmpl == nil {
+		return 0, 0, 0
+	}
+
+	pods = defaultMaxPods
+	if raw := tmpl.Annotations[podsAnnotation]; raw != "" {
+		if parsed, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			pods = parsed
+		}
+	}
+	if raw := tmpl.Annotations[cpuAnnotation]; raw != "" {
+		if parsed, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			cpu = parsed
+		}
+	}
+	if raw := tmpl.Annotations[memoryAnnotation]; raw != "" {
+		if parsed, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			memoryBytes = parsed * (1 << 20)
+		}
+	}
+	if cpu != 0 && memoryBytes != 0 {
+		return cpu, memoryBytes, pods
+	}
+
+	machineSize, _ := tmpl.DriverConfig["instanceType"].(string)
+	if flavor, found := defaultMachineFlavors[tmpl.Driver][machineSize]; found {
+		if cpu == 0 {
+			cpu = flavor.CPU
+		}
+		if memoryBytes == 0 {
+			memoryBytes = flavor.MemoryMiB * (1 << 20)
+		}
+	}
+	return cpu, memoryBytes, pods
+}
+
+// nodesInPool returns a snapshot of the cached nodes belonging to poolID,
+// safe to call concurrently with Refresh.
+func (m *RancherManager) nodesInPool(poolID string) []*rancherNode {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	var nodes []*rancherNode
+	for _, n := range m.nodes {
+		if n.NodePoolID == poolID {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// nodesInMachineDeployment returns a snapshot of the cached nodes backed by
+// the MachineDeployment named name, safe to call concurrently with
+// Refresh.
+func (m *RancherManager) nodesInMachineDeployment(name string) []*rancherNode {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	var nodes []*rancherNode
+	for _, n := range m.nodes {
+		if n.MachineDeploymentName == name {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// createNodePool builds a nodeTemplate for machineType using the manager's
+// configured driver and a theoretical nodePool bound to it, entirely
+// in-memory. Nothing is sent to the Rancher API until persistPool is
+// called from Create(): NewNodeGroup is invoked speculatively against many
+// candidate machine types during node-autoprovisioning simulation, most of
+// which are discarded, so writing a nodeTemplate here would leak an orphan
+// per candidate.
+func (m *RancherManager) createNodePool(machineType string, labels map[string]string, taints []apiv1.Taint) (*nodePool, error) {
+	tmpl := &nodeTemplate{
+		Name:         fmt.Sprintf("autoscaler-%s-%s", m.driver, machineType),
+		Driver:       m.driver,
+		DriverConfig: map[string]interface{}{"instanceType": machineType},
+	}
+
+	pool := &nodePool{
+		ClusterID:        m.clusterID,
+		Labels:           labels,
+		Taints:           taints,
+		theoretical:      true,
+		pendingTemplate:  tmpl,
+		theoreticalSince: time.Now(),
+	}
+
+	m.cacheMutex.Lock()
+	pool.ID = fmt.Sprintf("theoretical-%s", tmpl.Name)
+	m.nodePools[pool.ID] = pool
+	m.cacheMutex.Unlock()
+
+	return pool, nil
+}
+
+// persistPool realizes a theoretical nodePool in Rancher. If pool still
+// carries a pendingTemplate (built by createNodePool but never sent to the
+// API), the template is created first so the pool can reference its real
+// ID; this is the only point at which NewNodeGroup's candidate actually
+// gets written to Rancher.
+func (m *RancherManager) persistPool(pool *nodePool) (*nodePool, error) {
+	if pool.pendingTemplate != nil {
+		tmpl := &nodeTemplate{}
+		if err := m.do(http.MethodPost, "/nodeTemplates", pool.pendingTemplate, tmpl); err != nil {
+			return nil, fmt.Errorf("failed to create node template for %s: %v", pool.pendingTemplate.Name, err)
+		}
+		pool.NodeTemplateID = tmpl.ID
+		pool.pendingTemplate = nil
+
+		m.cacheMutex.Lock()
+		m.nodeTemplates[tmpl.ID] = tmpl
+		m.cacheMutex.Unlock()
+	}
+
+	created := &nodePool{}
+	if err := m.do(http.MethodPost, "/nodepools", pool, created); err != nil {
+		return nil, fmt.Errorf("failed to create node pool: %v", err)
+	}
+
+	m.cacheMutex.Lock()
+	delete(m.nodePools, pool.ID)
+	m.nodePools[created.ID] = created
+	m.cacheMutex.Unlock()
+
+	return created, nil
+}
+
+// Cleanup cleans up open resources before the cloud provider is destroyed.
+func (m *RancherManager) Cleanup() error {
+	return nil
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rancher
+
+import (
+	"fmt"
+	"net/http"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	schedulerframework "k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// rancherNodeGroup implements cloudprovider.NodeGroup backed by a Rancher
+// nodePool.
+type rancherNodeGroup struct {
+	manager *RancherManager
+	id      string
+}
+
+// templateNodeSuffix names the synthetic node TemplateNodeInfo builds for
+// scale-from-zero simulation: "<poolID>" + templateNodeSuffix. The pricing
+// model strips this suffix to price template nodes, which never appear in
+// RancherManager's cached real nodes.
+const templateNodeSuffix = "-template"
+
+// MaxSize returns maximum size of the node group.
+func (ng *rancherNodeGroup) MaxSize() int {
+	pool, err := ng.manager.getNodePool(ng.id)
+	if err != nil {
+		return 0
+	}
+	return pool.maxSize
+}
+
+// MinSize returns minimum size of the node group.
+func (ng *rancherNodeGroup) MinSize() int {
+	pool, err := ng.manager.getNodePool(ng.id)
+	if err != nil {
+		return 0
+	}
+	return pool.minSize
+}
+
+// TargetSize returns the current target size of the node group.
+func (ng *rancherNodeGroup) TargetSize() (int, error) {
+	pool, err := ng.manager.getNodePool(ng.id)
+	if err != nil {
+		return 0, err
+	}
+	return pool.Quantity, nil
+}
+
+// IncreaseSize increases the size of the node group by raising the
+// nodePool's desired quantity in Rancher.
+func (ng *rancherNodeGroup) IncreaseSize(delta int) error {
+	if delta <= 0 {
+		return fmt.Errorf("size increase must be positive, got: %d", delta)
+	}
+	pool, err := ng.manager.getNodePool(ng.id)
+	if err != nil {
+		return err
+	}
+	return ng.manager.do(http.MethodPut, "/nodepools/"+ng.id, map[string]int{"quantity": pool.Quantity + delta}, nil)
+}
+
+// DeleteNodes deletes nodes from this node group, lowering its quantity.
+func (ng *rancherNodeGroup) DeleteNodes(nodes []*apiv1.Node) error {
+	pool, err := ng.manager.getNodePool(ng.id)
+	if err != nil {
+		return err
+	}
+	if pool.Quantity-len(nodes) < ng.MinSize() {
+		return fmt.Errorf("node pool %s size would drop below minimum", ng.id)
+	}
+	for _, node := range nodes {
+		rn, err := ng.manager.GetCachedNodeForKubernetesNode(node.Name)
+		if err != nil {
+			return err
+		}
+		if err := ng.manager.do(http.MethodDelete, "/nodes/"+rn.ID, nil, nil); err != nil {
+			return fmt.Errorf("failed to delete node %s: %v", node.Name, err)
+		}
+	}
+	return ng.manager.do(http.MethodPut, "/nodepools/"+ng.id, map[string]int{"quantity": pool.Quantity - len(nodes)}, nil)
+}
+
+// DecreaseTargetSize decreases the target size of the node group.
+func (ng *rancherNodeGroup) DecreaseTargetSize(delta int) error {
+	if delta >= 0 {
+		return fmt.Errorf("size decrease must be negative, got: %d", delta)
+	}
+	pool, err := ng.manager.getNodePool(ng.id)
+	if err != nil {
+		return err
+	}
+	return ng.manager.do(http.MethodPut, "/nodepools/"+ng.id, map[string]int{"quantity": pool.Quantity + delta}, nil)
+}
+
+// Id returns the node pool ID backing this node group.
+func (ng *rancherNodeGroup) Id() string {
+	return ng.id
+}
+
+// Debug returns a human-readable description of the node group.
+func (ng *rancherNodeGroup) Debug() string {
+	return fmt.Sprintf("rancher node pool %s", ng.id)
+}
+
+// Nodes returns a list of instances belonging to this node group.
+func (ng *rancherNodeGroup) Nodes() ([]cloudprovider.Instance, error) {
+	pool, err := ng.manager.getNodePool(ng.id)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []cloudprovider.Instance
+	for _, n := range ng.manager.nodesInPool(pool.ID) {
+		instances = append(instances, cloudprovider.Instance{Id: n.ID})
+	}
+	return instances, nil
+}
+
+// TemplateNodeInfo returns a framework.NodeInfo structure of an empty
+// (template) node for this node group, used to simulate scale-from-zero.
+// CPU, memory, pods and GPU capacity are all derived from the pool's
+// nodeTemplate, since no real node exists yet to report them.
+func (ng *rancherNodeGroup) TemplateNodeInfo() (*schedulerframework.NodeInfo, error) {
+	pool, err := ng.manager.getNodePool(ng.id)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   ng.id + templateNodeSuffix,
+			Labels: pool.Labels,
+		},
+		Spec:   apiv1.NodeSpec{Taints: pool.Taints},
+		Status: apiv1.NodeStatus{Capacity: apiv1.ResourceList{}, Allocatable: apiv1.ResourceList{}},
+	}
+
+	if cpu, mem, pods := ng.manager.resourceSpecForPool(pool); cpu > 0 || mem > 0 || pods > 0 {
+		if cpu > 0 {
+			quantity := *resource.NewQuantity(cpu, resource.DecimalSI)
+			node.Status.Capacity[apiv1.ResourceCPU] = quantity
+			node.Status.Allocatable[apiv1.ResourceCPU] = quantity
+		}
+		if mem > 0 {
+			quantity := *resource.NewQuantity(mem, resource.BinarySI)
+			node.Status.Capacity[apiv1.ResourceMemory] = quantity
+			node.Status.Allocatable[apiv1.ResourceMemory] = quantity
+		}
+		if pods > 0 {
+			quantity := *resource.NewQuantity(pods, resource.DecimalSI)
+			node.Status.Capacity[apiv1.ResourcePods] = quantity
+			node.Status.Allocatable[apiv1.ResourcePods] = quantity
+		}
+	}
+
+	if gpuType, count := ng.manager.gpuSpecForPool(pool); gpuType != "" {
+		node.Labels = labelsWithGPUType(node.Labels, ng.manager.GPULabel(), gpuType)
+		quantity := *resource.NewQuantity(count, resource.DecimalSI)
+		node.Status.Capacity[gpuResourceName] = quantity
+		node.Status.Allocatable[gpuResourceName] = quantity
+	}
+
+	nodeInfo := schedulerframework.NewNodeInfo(cloudprovider.BuildKubeProxy(ng.id))
+	nodeInfo.SetNode(node)
+	return nodeInfo, nil
+}
+
+// labelsWithGPUType returns labels with the GPU label set to gpuType,
+// without mutating the original map.
+func labelsWithGPUType(labels map[string]string, gpuLabel, gpuType string) map[string]string {
+	out := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[gpuLabel] = gpuType
+	return out
+}
+
+// Exist checks if the node pool already exists in Rancher.
+func (ng *rancherNodeGroup) Exist() bool {
+	pool, err := ng.manager.getNodePool(ng.id)
+	if err != nil {
+		return false
+	}
+	return !pool.theoretical
+}
+
+// Create creates the theoretical node pool (and, if it hasn't been created
+// yet, its nodeTemplate) in Rancher and returns the resulting, now-real,
+// node group.
+func (ng *rancherNodeGroup) Create() (cloudprovider.NodeGroup, error) {
+	if ng.Exist() {
+		return nil, fmt.Errorf("node pool %s already exists", ng.id)
+	}
+	pool, err := ng.manager.getNodePool(ng.id)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := ng.manager.persistPool(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rancherNodeGroup{manager: ng.manager, id: created.ID}, nil
+}
+
+// Delete deletes the node pool from Rancher, to be used when the node
+// group was created by Create() and has no more nodes.
+func (ng *rancherNodeGroup) Delete() error {
+	return ng.manager.do(http.MethodDelete, "/nodepools/"+ng.id, nil, nil)
+}
+
+// Autoprovisioned returns true while the node group is a theoretical pool
+// built by NewNodeGroup and not yet persisted by Create(). Once Create()
+// has persisted it, or after the pool is reloaded by Refresh, it returns
+// false.
+func (ng *rancherNodeGroup) Autoprovisioned() bool {
+	pool, err := ng.manager.getNodePool(ng.id)
+	if err != nil {
+		return false
+	}
+	return pool.theoretical
+}
+
+// GetOptions returns NodeGroupAutoscalingOptions for this node group, or
+// nil if the default ones should be used.
+func (ng *rancherNodeGroup) GetOptions(defaults config.NodeGroupAutoscalingOptions) (*config.NodeGroupAutoscalingOptions, error) {
+	return nil, cloudprovider.ErrNotImplemented
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rancher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// hourlyCostAnnotation, when set on a nodePool or its nodeTemplate, gives
+// the node's hourly cost in USD directly, bypassing pricingTable lookups.
+// Useful for spot pools whose price fluctuates independently of their
+// driver/machine size.
+const hourlyCostAnnotation = "autoscaler.pkds.it/hourly-usd"
+
+// referenceNodeCPU and referenceNodeMemoryGiB describe the nominal node
+// shape PodPrice prices a pod's requests against: a pod requesting exactly
+// this much CPU and memory costs one reference node's hourly rate, split
+// evenly between the two resources.
+const (
+	referenceNodeCPU       = 4.0
+	referenceNodeMemoryGiB = 16.0
+)
+
+// pricingTable is a driver name -> machine size -> hourly USD lookup,
+// loadable from the file referenced by `--rancher-pricing-config` and
+// falling back to defaultPricingTable when no such flag is set.
+type pricingTable map[string]map[string]float64
+
+// defaultPricingTable covers the node drivers PlayKids runs in practice;
+// operators with other drivers should supply `--rancher-pricing-config`.
+var defaultPricingTable = pricingTable{
+	"amazonec2": {
+		"m5.large":   0.096,
+		"m5.xlarge":  0.192,
+		"m5.2xlarge": 0.384,
+	},
+	"digitalocean": {
+		"s-2vcpu-4gb": 0.030,
+		"s-4vcpu-8gb": 0.060,
+	},
+}
+
+// loadPricingTable reads a pricingTable from a YAML file shaped like:
+//
+//	amazonec2:
+//	  m5.large: 0.096
+//	digitalocean:
+//	  s-2vcpu-4gb: 0.03
+func loadPricingTable(path string) (pricingTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rancher pricing config %q: %v", path, err)
+	}
+	var table pricingTable
+	if err := yaml.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("failed to parse rancher pricing config %q: %v", path, err)
+	}
+	return table, nil
+}
+
+// rancherPricingModel implements cloudprovider.PricingModel for Rancher
+// node pools, reading a per-pool/per-template cost hint annotation and
+// falling back to a static driver+machine-size table.
+type rancherPricingModel struct {
+	manager *RancherManager
+	table   pricingTable
+}
+
+// NodePrice returns the cost, in USD, of running node between startTime
+// and endTime.
+func (pm *rancherPricingModel) NodePrice(node *apiv1.Node, startTime, endTime time.Time) (float64, error) {
+	hourly, err := pm.hourlyRate(node)
+	if err != nil {
+		return 0, err
+	}
+	return hourly * endTime.Sub(startTime).Hours(), nil
+}
+
+// hourlyRate resolves node's hourly USD cost: the annotation on its
+// nodePool, then on its nodeTemplate, then the pricing table keyed by
+// driver + machine size, derived from the nodeTemplate's driver config.
+// node may be a TemplateNodeInfo synthetic node (named "<poolID>-template"),
+// which has no entry in the manager's cached real nodes; its pool is
+// resolved directly from the name in that case.
+func (pm *rancherPricingModel) hourlyRate(node *apiv1.Node) (float64, error) {
+	var poolID string
+	if strings.HasSuffix(node.Name, templateNodeSuffix) {
+		poolID = strings.TrimSuffix(node.Name, templateNodeSuffix)
+	} else {
+		rn, err := pm.manager.GetCachedNodeForKubernetesNode(node.Name)
+		if err != nil {
+			return 0, err
+		}
+		if rn.NodePoolID == "" {
+			// CAPI-backed node groups don't have a nodePool/nodeTemplate to
+			// annotate; nothing to price against.
+			return 0, fmt.Errorf("no rancher node pool for node %s, cannot price", node.Name)
+		}
+		poolID = rn.NodePoolID
+	}
+
+	pool, err := pm.manager.getNodePool(poolID)
+	if err != nil {
+		return 0, err
+	}
+	if raw := pool.Annotations[hourlyCostAnnotation]; raw != "" {
+		return strconv.ParseFloat(raw, 64)
+	}
+
+	tmpl := pm.manager.resolveTemplate(pool)
+	if tmpl == nil {
+		return 0, fmt.Errorf("no node template for pool %s, cannot price", pool.ID)
+	}
+	if raw := tmpl.Annotations[hourlyCostAnnotation]; raw != "" {
+		return strconv.ParseFloat(raw, 64)
+	}
+
+	machineSize, _ := tmpl.DriverConfig["instanceType"].(string)
+	if machineSize == "" {
+		return 0, fmt.Errorf("no hourly-usd annotation and no known machine size for node %s", node.Name)
+	}
+	rate, found := pm.table[tmpl.Driver][machineSize]
+	if !found {
+		return 0, fmt.Errorf("no pricing entry for driver %s machine size %s", tmpl.Driver, machineSize)
+	}
+	return rate, nil
+}
+
+// PodPrice estimates the cost, in USD, of running pod between startTime
+// and endTime by prorating the cheapest known node's hourly rate across
+// the fraction of a reference node (see referenceNodeCPU/
+// referenceNodeMemoryGiB) the pod's requested CPU and memory represent,
+// since no node has necessarily been assigned to it yet. This is a rough
+// scale-from-zero estimate, not an exact price.
+func (pm *rancherPricingModel) PodPrice(pod *apiv1.Pod, startTime, endTime time.Time) (float64, error) {
+	referenceHourly := pm.cheapestHourlyRate()
+	if referenceHourly == 0 {
+		return 0, nil
+	}
+
+	cpu, mem := resource.Quantity{}, resource.Quantity{}
+	for _, container := range pod.Spec.Containers {
+		if c, ok := container.Resources.Requests[apiv1.ResourceCPU]; ok {
+			cpu.Add(c)
+		}
+		if m, ok := container.Resources.Requests[apiv1.ResourceMemory]; ok {
+			mem.Add(m)
+		}
+	}
+
+	hours := endTime.Sub(startTime).Hours()
+	cpuFraction := cpu.AsApproximateFloat64() / referenceNodeCPU
+	memFraction := (mem.AsApproximateFloat64() / (1 << 30)) / referenceNodeMemoryGiB
+	return (cpuFraction + memFraction) / 2 * referenceHourly * hours, nil
+}
+
+// cheapestHourlyRate returns the lowest hourly rate in the pricing table,
+// used as PodPrice's reference-node baseline, or 0 if the table is empty.
+func (pm *rancherPricingModel) cheapestHourlyRate() float64 {
+	cheapest := 0.0
+	for _, sizes := range pm.table {
+		for _, rate := range sizes {
+			if cheapest == 0 || rate < cheapest {
+				cheapest = rate
+			}
+		}
+	}
+	return cheapest
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rancher
+
+import "testing"
+
+func TestCheapestHourlyRate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		table pricingTable
+		want  float64
+	}{
+		{name: "empty table", table: pricingTable{}, want: 0},
+		{name: "nil table", table: nil, want: 0},
+		{
+			name: "picks lowest across drivers",
+			table: pricingTable{
+				"amazonec2": {
+					"m5.large":  0.096,
+					"m5.xlarge": 0.192,
+				},
+				"digitalocean": {
+					"s-2vcpu-4gb": 0.030,
+				},
+			},
+			want: 0.030,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pm := &rancherPricingModel{table: tc.table}
+			if got := pm.cheapestHourlyRate(); got != tc.want {
+				t.Errorf("cheapestHourlyRate() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}